@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// tokenSafetyMargin is subtracted from a token's reported expiry so we
+// refresh slightly before the registry actually rejects it.
+const tokenSafetyMargin = 10 * time.Second
+
+// credential is a static username/password pair for a single registry
+// host, as configured via EDGENEST_AUTH_<host>.
+type credential struct {
+	username string
+	password string
+}
+
+// cachedToken is a Bearer token scoped to a single (registry, scope) pair.
+type cachedToken struct {
+	token     string
+	expiresAt time.Time
+}
+
+func (t cachedToken) valid() bool {
+	return t.token != "" && time.Now().Before(t.expiresAt)
+}
+
+// Authenticator implements the OCI/Docker registry token auth flow: on a
+// 401 with a Bearer challenge, it resolves static credentials, exchanges
+// them for a short-lived token at the challenge's realm, and caches that
+// token per (registry, scope) until it's close to expiring.
+type Authenticator struct {
+	client      *http.Client
+	credentials map[string]credential
+
+	mu     sync.Mutex
+	tokens map[string]cachedToken
+}
+
+// NewAuthenticator builds an Authenticator with static credentials loaded
+// from EDGENEST_AUTH_<host> environment variables (value "user:pass").
+func NewAuthenticator() *Authenticator {
+	return &Authenticator{
+		client:      &http.Client{},
+		credentials: credentialsFromEnv(),
+		tokens:      make(map[string]cachedToken),
+	}
+}
+
+func credentialsFromEnv() map[string]credential {
+	const prefix = "EDGENEST_AUTH_"
+	creds := make(map[string]credential)
+	for _, kv := range os.Environ() {
+		key, value, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		host := strings.TrimPrefix(key, prefix)
+		user, pass, ok := strings.Cut(value, ":")
+		if !ok {
+			continue
+		}
+		creds[host] = credential{username: user, password: pass}
+	}
+	return creds
+}
+
+// bearerChallenge is a parsed "Www-Authenticate: Bearer ..." header.
+type bearerChallenge struct {
+	realm   string
+	service string
+	scopes  []string
+}
+
+var (
+	bearerSchemeRegex = regexp.MustCompile(`(?i)^\s*Bearer\s+(.*)$`)
+	bearerParamRegex  = regexp.MustCompile(`([a-zA-Z0-9_]+)="([^"]*)"`)
+)
+
+// parseBearerChallenge parses a Www-Authenticate header of the form
+// `Bearer realm="...",service="...",scope="repository:library/alpine:pull"`,
+// tolerating multiple comma-separated params and repeated scope params.
+func parseBearerChallenge(header string) (bearerChallenge, error) {
+	m := bearerSchemeRegex.FindStringSubmatch(header)
+	if m == nil {
+		return bearerChallenge{}, fmt.Errorf("not a Bearer challenge: %q", header)
+	}
+
+	var c bearerChallenge
+	for _, pm := range bearerParamRegex.FindAllStringSubmatch(m[1], -1) {
+		switch strings.ToLower(pm[1]) {
+		case "realm":
+			c.realm = pm[2]
+		case "service":
+			c.service = pm[2]
+		case "scope":
+			c.scopes = append(c.scopes, pm[2])
+		}
+	}
+	if c.realm == "" {
+		return bearerChallenge{}, fmt.Errorf("Bearer challenge missing realm: %q", header)
+	}
+	return c, nil
+}
+
+// tokenResponse mirrors the registry token endpoint's response body. Some
+// registries return "token", others "access_token"; we accept either.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+}
+
+// tokenFor resolves a Bearer token for the given challenge against host,
+// serving from cache when a non-expired token is already held.
+func (a *Authenticator) tokenFor(host string, c bearerChallenge) (string, error) {
+	key := host + "|" + strings.Join(c.scopes, " ")
+
+	a.mu.Lock()
+	if tok, ok := a.tokens[key]; ok && tok.valid() {
+		a.mu.Unlock()
+		return tok.token, nil
+	}
+	a.mu.Unlock()
+
+	tok, expiresAt, err := a.fetchToken(host, c)
+	if err != nil {
+		return "", err
+	}
+
+	a.mu.Lock()
+	a.tokens[key] = cachedToken{token: tok, expiresAt: expiresAt}
+	a.mu.Unlock()
+
+	return tok, nil
+}
+
+// cachedTokenFor returns a still-valid token already cached for (host,
+// scope), making no network call, so a request can have Authorization
+// attached proactively instead of only after a reactive 401. The key
+// format matches tokenFor's single-scope case; challenges that carry
+// multiple scopes still work, just via the normal 401 round trip below.
+func (a *Authenticator) cachedTokenFor(host, scope string) (string, bool) {
+	key := host + "|" + scope
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	tok, ok := a.tokens[key]
+	if !ok || !tok.valid() {
+		return "", false
+	}
+	return tok.token, true
+}
+
+func (a *Authenticator) fetchToken(host string, c bearerChallenge) (string, time.Time, error) {
+	u, err := url.Parse(c.realm)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("parsing token realm: %w", err)
+	}
+
+	q := u.Query()
+	if c.service != "" {
+		q.Set("service", c.service)
+	}
+	for _, scope := range c.scopes {
+		q.Add("scope", scope)
+	}
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	if cred, ok := a.credentials[host]; ok {
+		req.SetBasicAuth(cred.username, cred.password)
+	}
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", time.Time{}, fmt.Errorf("requesting token: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", time.Time{}, fmt.Errorf("token endpoint returned %d", resp.StatusCode)
+	}
+
+	var body tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", time.Time{}, fmt.Errorf("decoding token response: %w", err)
+	}
+
+	tok := body.Token
+	if tok == "" {
+		tok = body.AccessToken
+	}
+	if tok == "" {
+		return "", time.Time{}, fmt.Errorf("token response had no token")
+	}
+
+	expiresIn := body.ExpiresIn
+	if expiresIn <= 0 {
+		expiresIn = 60
+	}
+	expiresAt := time.Now().Add(time.Duration(expiresIn)*time.Second - tokenSafetyMargin)
+
+	return tok, expiresAt, nil
+}
+
+// authRoundTripper wraps a base transport. It proactively attaches a
+// still-valid cached Bearer token for the request's repository scope, and
+// otherwise falls back to retrying a request exactly once with a fresh
+// token when upstream responds 401 with a challenge.
+type authRoundTripper struct {
+	base http.RoundTripper
+	auth *Authenticator
+}
+
+// repositoryScopeForRequest derives the "repository:<name>:pull" scope a
+// manifest or blob request needs, so authRoundTripper can check for an
+// already-cached token before making any request at all.
+func repositoryScopeForRequest(req *http.Request) (string, bool) {
+	if m := manifestPathRegex.FindStringSubmatch(req.URL.Path); m != nil {
+		return "repository:" + m[1] + ":pull", true
+	}
+	if m := blobPathRegex.FindStringSubmatch(req.URL.Path); m != nil {
+		return "repository:" + m[1] + ":pull", true
+	}
+	return "", false
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	attachedScope := ""
+	if scope, ok := repositoryScopeForRequest(req); ok {
+		if tok, ok := rt.auth.cachedTokenFor(req.URL.Host, scope); ok {
+			authed := req.Clone(req.Context())
+			authed.Header.Set("Authorization", "Bearer "+tok)
+			req = authed
+			attachedScope = scope
+		}
+	}
+
+	resp, err := rt.base.RoundTrip(req)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized {
+		return resp, err
+	}
+
+	challenge := resp.Header.Get("Www-Authenticate")
+	if challenge == "" {
+		return resp, nil
+	}
+	c, err := parseBearerChallenge(challenge)
+	if err != nil {
+		return resp, nil
+	}
+
+	// Upstream rejected the cached token we attached, so drop it and make
+	// tokenFor fetch a new one for the retry.
+	if attachedScope != "" {
+		rt.auth.mu.Lock()
+		delete(rt.auth.tokens, req.URL.Host+"|"+attachedScope)
+		delete(rt.auth.tokens, req.URL.Host+"|"+strings.Join(c.scopes, " "))
+		rt.auth.mu.Unlock()
+	}
+
+	tok, err := rt.auth.tokenFor(req.URL.Host, c)
+	if err != nil {
+		return resp, nil
+	}
+	resp.Body.Close()
+
+	retryReq := req.Clone(req.Context())
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return resp, nil
+		}
+		retryReq.Body = body
+	}
+	retryReq.Header.Set("Authorization", "Bearer "+tok)
+
+	return rt.base.RoundTrip(retryReq)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestParseBearerChallenge(t *testing.T) {
+	t.Run("parses realm, service, and a single scope", func(t *testing.T) {
+		header := `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"`
+
+		c, err := parseBearerChallenge(header)
+		if err != nil {
+			t.Fatalf("parseBearerChallenge returned error: %v", err)
+		}
+		if c.realm != "https://auth.docker.io/token" {
+			t.Errorf("realm = %q, want %q", c.realm, "https://auth.docker.io/token")
+		}
+		if c.service != "registry.docker.io" {
+			t.Errorf("service = %q, want %q", c.service, "registry.docker.io")
+		}
+		if len(c.scopes) != 1 || c.scopes[0] != "repository:library/alpine:pull" {
+			t.Errorf("scopes = %v, want [repository:library/alpine:pull]", c.scopes)
+		}
+	})
+
+	t.Run("accepts multiple scope params", func(t *testing.T) {
+		header := `Bearer realm="https://auth.example.com/token",service="registry.example.com",scope="repository:a:pull",scope="repository:b:pull"`
+
+		c, err := parseBearerChallenge(header)
+		if err != nil {
+			t.Fatalf("parseBearerChallenge returned error: %v", err)
+		}
+		if len(c.scopes) != 2 {
+			t.Fatalf("scopes = %v, want 2 entries", c.scopes)
+		}
+	})
+
+	t.Run("rejects a non-Bearer challenge", func(t *testing.T) {
+		if _, err := parseBearerChallenge(`Basic realm="registry"`); err == nil {
+			t.Error("expected an error for a Basic challenge")
+		}
+	})
+}
+
+// Behaviour:
+//   - On a 401 with a Bearer challenge, EdgeNest should fetch a token from
+//     the challenge's realm, retry the original request with it, and
+//     return the retried response to the client.
+func TestAuthRoundTripperRetriesWithToken(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.URL.Query().Get("scope"); got != "repository:library/alpine:pull" {
+			t.Errorf("token request scope = %q, want %q", got, "repository:library/alpine:pull")
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"token":"testtoken123","expires_in":60}`))
+	}))
+	defer tokenServer.Close()
+
+	const manifestBody = `{"schemaVersion":2}`
+	const manifestDigest = "sha256:c0ffee"
+	const manifestPath = "/v2/library/alpine/manifests/latest"
+
+	registryCallCount := 0
+	registryHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryCallCount++
+		if r.Header.Get("Authorization") != "Bearer testtoken123" {
+			w.Header().Set("Www-Authenticate",
+				`Bearer realm="`+tokenServer.URL+`",service="registry",scope="repository:library/alpine:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.docker.distribution.manifest.v2+json")
+		w.Header().Set("Docker-Content-Digest", manifestDigest)
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(manifestBody))
+	})
+
+	mux := setupEdgeNestHandler(t, registryHandler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, manifestPath, nil)
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if registryCallCount != 2 {
+		t.Fatalf("registry called %d times, want 2 (401 then authorized retry)", registryCallCount)
+	}
+	if got := rec.Body.String(); got != manifestBody {
+		t.Errorf("body = %q, want %q", got, manifestBody)
+	}
+}
+
+// Behaviour:
+//   - Once a token is cached for a (registry, repository) pair, later
+//     requests to that same repository must attach it up front instead of
+//     always eating a 401 round trip first. Uses two distinct blobs in the
+//     same repository so the second request can't be answered from the
+//     manifest/blob cache and must actually reach authRoundTripper again.
+func TestAuthRoundTripperAttachesCachedTokenProactively(t *testing.T) {
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"token":"testtoken123","expires_in":3600}`))
+	}))
+	defer tokenServer.Close()
+
+	const blobBodyA = "layer-contents-a"
+	const blobBodyB = "layer-contents-b"
+	digestA := digestOf(blobBodyA)
+	digestB := digestOf(blobBodyB)
+
+	registryCallCount := 0
+	registryHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		registryCallCount++
+		if r.Header.Get("Authorization") != "Bearer testtoken123" {
+			w.Header().Set("Www-Authenticate",
+				`Bearer realm="`+tokenServer.URL+`",service="registry",scope="repository:library/alpine:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		if strings.HasSuffix(r.URL.Path, digestA) {
+			_, _ = io.WriteString(w, blobBodyA)
+		} else {
+			_, _ = io.WriteString(w, blobBodyB)
+		}
+	})
+
+	mux := setupEdgeNestHandler(t, registryHandler)
+
+	rec1 := httptest.NewRecorder()
+	req1 := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/blobs/"+digestA, nil)
+	mux.ServeHTTP(rec1, req1)
+	if rec1.Code != http.StatusOK {
+		t.Fatalf("first blob status = %d, want %d", rec1.Code, http.StatusOK)
+	}
+	if registryCallCount != 2 {
+		t.Fatalf("registry called %d times for first blob, want 2 (401 then authorized retry)", registryCallCount)
+	}
+
+	rec2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodGet, "/v2/library/alpine/blobs/"+digestB, nil)
+	mux.ServeHTTP(rec2, req2)
+	if rec2.Code != http.StatusOK {
+		t.Fatalf("second blob status = %d, want %d", rec2.Code, http.StatusOK)
+	}
+	if registryCallCount != 3 {
+		t.Errorf("registry called %d times total, want 3 (cached token attached up front for the second blob)", registryCallCount)
+	}
+	if got := rec2.Body.String(); got != blobBodyB {
+		t.Errorf("second blob body = %q, want %q", got, blobBodyB)
+	}
+}
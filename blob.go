@@ -0,0 +1,225 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// defaultBlobCacheDir creates a fresh, randomly named temporary directory
+// to cache blobs in. Each EdgeNest gets its own, so blobs cached by one
+// process are not found again by an EdgeNest started later.
+func defaultBlobCacheDir() (string, error) {
+	dir, err := os.MkdirTemp("", "edgenest-blobs-")
+	if err != nil {
+		return "", fmt.Errorf("creating blob cache dir: %w", err)
+	}
+	return dir, nil
+}
+
+// blobCachePath maps a digest like "sha256:abcd..." to its on-disk path,
+// sharding by the first two hex characters so no single directory ends up
+// with one entry per blob in the registry.
+func (e *EdgeNest) blobCachePath(digest string) (string, error) {
+	algo, hexDigest, ok := strings.Cut(digest, ":")
+	if !ok || algo != "sha256" || len(hexDigest) != 64 {
+		return "", fmt.Errorf("unsupported or malformed digest %q", digest)
+	}
+	return filepath.Join(e.blobCacheDir, algo, hexDigest[:2], hexDigest[2:]), nil
+}
+
+func (e *EdgeNest) handleBlob(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m := blobPathRegex.FindStringSubmatch(r.URL.Path)
+	digest := m[2]
+
+	path, err := e.blobCachePath(digest)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		if info, err := os.Stat(path); err == nil {
+			w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+			w.Header().Set("Content-Type", "application/octet-stream")
+			w.Header().Set("Docker-Content-Digest", digest)
+			w.WriteHeader(http.StatusOK)
+			return
+		}
+		// Not cached - let upstream answer the existence check.
+		e.proxy.ServeHTTP(w, r)
+		return
+	}
+
+	// Range requests go straight upstream every time: we only ever cache
+	// fully-downloaded, digest-verified blobs, and a range is inherently
+	// per-request so there's nothing to coalesce.
+	if r.Header.Get("Range") != "" {
+		e.fetchAndForwardBlob(w, r, digest)
+		return
+	}
+
+	// Already on disk - serve it directly without involving singleflight
+	// or touching upstream at all.
+	if _, err := os.Stat(path); err != nil {
+		// Coalesce concurrent full downloads of the same blob onto a
+		// single upstream call; once the leader's download lands in the
+		// cache, every waiter (leader included) just streams the cached
+		// file.
+		sfKey := "blob " + digest
+		_, err, _ = e.sf.Do(sfKey, func() (interface{}, error) {
+			return nil, e.downloadAndCacheBlob(context.Background(), r.URL.Path, digest, path)
+		})
+
+		var statusErr *upstreamStatusError
+		if errors.As(err, &statusErr) {
+			for k, vals := range statusErr.header {
+				for _, v := range vals {
+					w.Header().Add(k, v)
+				}
+			}
+			w.WriteHeader(statusErr.status)
+			w.Write(statusErr.body)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Docker-Content-Digest", digest)
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, f)
+}
+
+// fetchAndForwardBlob proxies a single (typically ranged) blob GET
+// straight through to upstream without touching the cache.
+func (e *EdgeNest) fetchAndForwardBlob(w http.ResponseWriter, r *http.Request, digest string) {
+	upstreamURL := *e.upstreamURL
+	upstreamURL.Path = r.URL.Path
+	upstreamURL.RawQuery = r.URL.RawQuery
+
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, upstreamURL.String(), nil)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if rng := r.Header.Get("Range"); rng != "" {
+		req.Header.Set("Range", rng)
+	}
+
+	resp, err := e.client().Do(req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	defer resp.Body.Close()
+
+	for k, vals := range resp.Header {
+		for _, v := range vals {
+			w.Header().Add(k, v)
+		}
+	}
+	w.WriteHeader(resp.StatusCode)
+	io.Copy(w, resp.Body)
+}
+
+// upstreamStatusError carries a non-200 upstream response through
+// singleflight so every coalesced waiter can still see the real status
+// and body, rather than a generic error.
+type upstreamStatusError struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+func (e *upstreamStatusError) Error() string {
+	return fmt.Sprintf("upstream returned status %d", e.status)
+}
+
+// downloadAndCacheBlob fetches digest's full content from upstream (at
+// requestPath), streaming it to a temp cache file and a hasher at once,
+// verifies the computed digest, and renames the temp file into place.
+// It's always called through e.sf so concurrent callers share one
+// download.
+func (e *EdgeNest) downloadAndCacheBlob(ctx context.Context, requestPath, digest, cachePath string) error {
+	upstreamURL := *e.upstreamURL
+	upstreamURL.Path = requestPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, upstreamURL.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := e.client().Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return &upstreamStatusError{status: resp.StatusCode, header: resp.Header.Clone(), body: body}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(cachePath), 0o755); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(cachePath), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	hasher := sha256.New()
+	_, copyErr := io.Copy(io.MultiWriter(tmp, hasher), resp.Body)
+	tmp.Close()
+	if copyErr != nil {
+		os.Remove(tmpPath)
+		return copyErr
+	}
+
+	algo, _, _ := strings.Cut(digest, ":")
+	computed := algo + ":" + hex.EncodeToString(hasher.Sum(nil))
+	if computed != digest {
+		os.Remove(tmpPath)
+		return fmt.Errorf("digest mismatch: computed %s, want %s", computed, digest)
+	}
+
+	if err := os.Rename(tmpPath, cachePath); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func digestOf(body string) string {
+	sum := sha256.Sum256([]byte(body))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Behaviour:
+//   - Given a GET /v2/<name>/blobs/<digest> request, EdgeNest must proxy it
+//     to upstream, stream it to the client, and cache it on disk keyed by
+//     digest so a repeat request doesn't hit upstream again.
+func TestBlobGET(t *testing.T) {
+	const blobBody = "layer-contents"
+	digest := digestOf(blobBody)
+	blobPath := "/v2/library/alpine/blobs/" + digest
+
+	upstreamCallCount := 0
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCallCount++
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, blobBody)
+	})
+
+	mux := setupEdgeNestHandler(t, upstreamHandler)
+
+	rec1 := httptest.NewRecorder()
+	req1 := httptest.NewRequest(http.MethodGet, blobPath, nil)
+	mux.ServeHTTP(rec1, req1)
+
+	if rec1.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec1.Code, http.StatusOK)
+	}
+	if got := rec1.Body.String(); got != blobBody {
+		t.Fatalf("body = %q, want %q", got, blobBody)
+	}
+	if got := rec1.Header().Get("Docker-Content-Digest"); got != digest {
+		t.Errorf("Docker-Content-Digest = %q, want %q", got, digest)
+	}
+	if upstreamCallCount != 1 {
+		t.Fatalf("upstreamCallCount = %d, want 1", upstreamCallCount)
+	}
+
+	rec2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodGet, blobPath, nil)
+	mux.ServeHTTP(rec2, req2)
+
+	if got := rec2.Body.String(); got != blobBody {
+		t.Fatalf("cached body = %q, want %q", got, blobBody)
+	}
+	if upstreamCallCount != 1 {
+		t.Errorf("upstream should not be called again on cache hit, called %d times", upstreamCallCount)
+	}
+}
+
+// Behaviour:
+//   - If the bytes received from upstream don't hash to the requested
+//     digest, EdgeNest must not cache them, so the next request tries
+//     upstream again instead of serving corrupt data.
+func TestBlobGETDigestMismatchNotCached(t *testing.T) {
+	const wrongBody = "not-what-you-asked-for"
+	digest := digestOf("expected-contents")
+	blobPath := "/v2/library/alpine/blobs/" + digest
+
+	upstreamCallCount := 0
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamCallCount++
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, wrongBody)
+	})
+
+	mux := setupEdgeNestHandler(t, upstreamHandler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, blobPath, nil)
+	mux.ServeHTTP(rec, req)
+
+	rec2 := httptest.NewRecorder()
+	req2 := httptest.NewRequest(http.MethodGet, blobPath, nil)
+	mux.ServeHTTP(rec2, req2)
+
+	if upstreamCallCount != 2 {
+		t.Errorf("upstream should be retried after a digest mismatch, called %d times", upstreamCallCount)
+	}
+}
+
+// Behaviour:
+//   - Range requests must be forwarded upstream and the 206 response
+//     passed through as-is, without ever entering the blob cache.
+func TestBlobGETRangeNotCached(t *testing.T) {
+	const blobBody = "0123456789"
+	digest := digestOf(blobBody)
+	blobPath := "/v2/library/alpine/blobs/" + digest
+
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if rng := r.Header.Get("Range"); rng != "" {
+			w.Header().Set("Content-Range", "bytes 0-3/10")
+			w.WriteHeader(http.StatusPartialContent)
+			_, _ = io.WriteString(w, blobBody[:4])
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, blobBody)
+	})
+
+	mux := setupEdgeNestHandler(t, upstreamHandler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, blobPath, nil)
+	req.Header.Set("Range", "bytes=0-3")
+	mux.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusPartialContent {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusPartialContent)
+	}
+	if got := rec.Body.String(); got != blobBody[:4] {
+		t.Fatalf("body = %q, want %q", got, blobBody[:4])
+	}
+}
+
+// Behaviour:
+//   - HEAD for a cached blob must return Content-Length, Content-Type, and
+//     Docker-Content-Digest without contacting upstream.
+func TestBlobHEADCacheHit(t *testing.T) {
+	const blobBody = "layer-contents"
+	digest := digestOf(blobBody)
+	blobPath := "/v2/library/alpine/blobs/" + digest
+
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, blobBody)
+	})
+
+	mux := setupEdgeNestHandler(t, upstreamHandler)
+
+	// Warm the cache with a GET first.
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, blobPath, nil)
+	mux.ServeHTTP(rec, req)
+
+	headRec := httptest.NewRecorder()
+	headReq := httptest.NewRequest(http.MethodHead, blobPath, nil)
+	mux.ServeHTTP(headRec, headReq)
+
+	if headRec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", headRec.Code, http.StatusOK)
+	}
+	if got := headRec.Header().Get("Content-Length"); got != "14" {
+		t.Errorf("Content-Length = %q, want %q", got, "14")
+	}
+	if got := headRec.Header().Get("Content-Type"); got != "application/octet-stream" {
+		t.Errorf("Content-Type = %q, want %q", got, "application/octet-stream")
+	}
+	if got := headRec.Header().Get("Docker-Content-Digest"); got != digest {
+		t.Errorf("Docker-Content-Digest = %q, want %q", got, digest)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultMemoryCacheBytes bounds the in-memory tier's size when none is
+// given explicitly (e.g. via NewCache, used by tests and simple setups).
+const defaultMemoryCacheBytes = 64 << 20 // 64 MiB
+
+// CachedResponse is a cacheable manifest response, keyed and addressed as
+// described on manifestContentKey/manifestTagKey. Blobs are cached
+// separately, as content-addressed files under EdgeNest.blobCacheDir
+// (see blob.go); they don't go through Cache.
+type CachedResponse struct {
+	Body                []byte
+	ContentType         string
+	DockerContentDigest string
+	StatusCode          int
+	FetchedAt           time.Time
+}
+
+func (r *CachedResponse) size() int64 {
+	return int64(len(r.Body))
+}
+
+// Cache stores manifest responses keyed by an opaque string key so
+// handlers don't need to know which tier served a hit.
+type Cache interface {
+	Get(key string) (*CachedResponse, bool)
+	Put(key string, resp *CachedResponse)
+	Delete(key string)
+}
+
+// NewCache returns a sensibly-sized, memory-only Cache suitable for
+// tests and small deployments. Larger deployments should compose a
+// MemoryCache with a DiskCache via NewTieredCache instead.
+func NewCache() Cache {
+	return NewMemoryCache(defaultMemoryCacheBytes)
+}
+
+// MemoryCache is an LRU cache bounded by total response bytes rather than
+// entry count, since manifests and blobs vary wildly in size.
+type MemoryCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	curBytes int64
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key  string
+	resp *CachedResponse
+}
+
+func NewMemoryCache(maxBytes int64) *MemoryCache {
+	return &MemoryCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+func (c *MemoryCache) Get(key string) (*CachedResponse, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*memoryEntry).resp, true
+}
+
+func (c *MemoryCache) Put(key string, resp *CachedResponse) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.curBytes -= el.Value.(*memoryEntry).resp.size()
+		el.Value = &memoryEntry{key: key, resp: resp}
+		c.ll.MoveToFront(el)
+	} else {
+		el := c.ll.PushFront(&memoryEntry{key: key, resp: resp})
+		c.items[key] = el
+	}
+	c.curBytes += resp.size()
+
+	for c.curBytes > c.maxBytes {
+		back := c.ll.Back()
+		if back == nil {
+			break
+		}
+		c.evict(back)
+	}
+}
+
+func (c *MemoryCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.evict(el)
+	}
+}
+
+// evict removes el from the list and index; callers must hold c.mu.
+func (c *MemoryCache) evict(el *list.Element) {
+	entry := el.Value.(*memoryEntry)
+	c.ll.Remove(el)
+	delete(c.items, entry.key)
+	c.curBytes -= entry.resp.size()
+}
+
+// diskHeaders is the JSON sidecar stored alongside each cached body,
+// keeping the original key so the index can be rebuilt on startup.
+type diskHeaders struct {
+	Key                 string    `json:"key"`
+	ContentType         string    `json:"content_type"`
+	DockerContentDigest string    `json:"docker_content_digest"`
+	StatusCode          int       `json:"status_code"`
+	FetchedAt           time.Time `json:"fetched_at"`
+}
+
+// defaultDiskCacheBytes is a sensible maxBytes to pass to NewDiskCache,
+// which has no default of its own and always takes maxBytes explicitly.
+const defaultDiskCacheBytes = 1 << 30 // 1 GiB
+
+// diskEntryMeta is the in-memory index DiskCache keeps per on-disk entry,
+// just enough to pick an eviction victim without re-reading every headers
+// file from disk on every Put.
+type diskEntryMeta struct {
+	size      int64
+	fetchedAt time.Time
+}
+
+// DiskCache stores each entry as a `<hash>.headers.json` / `<hash>.body`
+// pair under dir, and rebuilds its index by scanning the headers files on
+// startup. It's bounded by maxBytes, evicting the oldest entries (by
+// FetchedAt) once over budget, the same policy MemoryCache applies by
+// recency instead.
+type DiskCache struct {
+	dir      string
+	maxBytes int64
+
+	mu       sync.Mutex
+	curBytes int64
+	entries  map[string]diskEntryMeta
+}
+
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("creating disk cache dir: %w", err)
+	}
+	c := &DiskCache{dir: dir, maxBytes: maxBytes, entries: make(map[string]diskEntryMeta)}
+	if err := c.scan(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *DiskCache) scan() error {
+	matches, err := filepath.Glob(filepath.Join(c.dir, "*.headers.json"))
+	if err != nil {
+		return err
+	}
+	var total int64
+	for _, headersPath := range matches {
+		raw, err := os.ReadFile(headersPath)
+		if err != nil {
+			continue
+		}
+		var h diskHeaders
+		if err := json.Unmarshal(raw, &h); err != nil {
+			continue
+		}
+		info, err := os.Stat(bodyPathFor(headersPath))
+		if err != nil {
+			continue
+		}
+		c.entries[h.Key] = diskEntryMeta{size: info.Size(), fetchedAt: h.FetchedAt}
+		total += info.Size()
+	}
+	c.curBytes = total
+	return nil
+}
+
+func bodyPathFor(headersPath string) string {
+	return headersPath[:len(headersPath)-len(".headers.json")] + ".body"
+}
+
+// diskCacheFilename maps a cache key (which may contain "/" and other
+// characters unsafe in a filename) to a stable, flat filename.
+func diskCacheFilename(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *DiskCache) pathsFor(key string) (headersPath, bodyPath string) {
+	base := filepath.Join(c.dir, diskCacheFilename(key))
+	return base + ".headers.json", base + ".body"
+}
+
+func (c *DiskCache) Get(key string) (*CachedResponse, bool) {
+	headersPath, bodyPath := c.pathsFor(key)
+
+	// Hold the lock across both reads so a concurrent Put can't land its
+	// body write in between them, which would otherwise pair up headers
+	// (e.g. Docker-Content-Digest) from one write with a body from
+	// another.
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	raw, err := os.ReadFile(headersPath)
+	if err != nil {
+		return nil, false
+	}
+	var h diskHeaders
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, false
+	}
+
+	body, err := os.ReadFile(bodyPath)
+	if err != nil {
+		return nil, false
+	}
+
+	return &CachedResponse{
+		Body:                body,
+		ContentType:         h.ContentType,
+		DockerContentDigest: h.DockerContentDigest,
+		StatusCode:          h.StatusCode,
+		FetchedAt:           h.FetchedAt,
+	}, true
+}
+
+func (c *DiskCache) Put(key string, resp *CachedResponse) {
+	headersPath, bodyPath := c.pathsFor(key)
+
+	h := diskHeaders{
+		Key:                 key,
+		ContentType:         resp.ContentType,
+		DockerContentDigest: resp.DockerContentDigest,
+		StatusCode:          resp.StatusCode,
+		FetchedAt:           resp.FetchedAt,
+	}
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if old, ok := c.entries[key]; ok {
+		c.curBytes -= old.size
+	}
+	if err := os.WriteFile(bodyPath, resp.Body, 0o644); err != nil {
+		return
+	}
+	if err := os.WriteFile(headersPath, raw, 0o644); err != nil {
+		os.Remove(bodyPath)
+		return
+	}
+	c.entries[key] = diskEntryMeta{size: int64(len(resp.Body)), fetchedAt: resp.FetchedAt}
+	c.curBytes += int64(len(resp.Body))
+
+	c.evictOverBudgetLocked()
+}
+
+func (c *DiskCache) Delete(key string) {
+	headersPath, bodyPath := c.pathsFor(key)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.deleteLocked(key, headersPath, bodyPath)
+}
+
+// deleteLocked removes an entry's files and index state; callers must
+// hold c.mu.
+func (c *DiskCache) deleteLocked(key, headersPath, bodyPath string) {
+	if old, ok := c.entries[key]; ok {
+		c.curBytes -= old.size
+		delete(c.entries, key)
+	}
+	os.Remove(headersPath)
+	os.Remove(bodyPath)
+}
+
+// evictOverBudgetLocked removes the oldest entries (by FetchedAt) until
+// the cache is back under maxBytes; callers must hold c.mu.
+func (c *DiskCache) evictOverBudgetLocked() {
+	for c.curBytes > c.maxBytes {
+		var oldestKey string
+		var oldest diskEntryMeta
+		found := false
+		for k, meta := range c.entries {
+			if !found || meta.fetchedAt.Before(oldest.fetchedAt) {
+				oldestKey, oldest = k, meta
+				found = true
+			}
+		}
+		if !found {
+			break
+		}
+		headersPath, bodyPath := c.pathsFor(oldestKey)
+		c.deleteLocked(oldestKey, headersPath, bodyPath)
+	}
+}
+
+// TieredCache checks memory first, falls back to disk and promotes on
+// hit, and writes through to both tiers on Put.
+type TieredCache struct {
+	mem  Cache
+	disk Cache
+}
+
+func NewTieredCache(mem Cache, disk Cache) *TieredCache {
+	return &TieredCache{mem: mem, disk: disk}
+}
+
+func (c *TieredCache) Get(key string) (*CachedResponse, bool) {
+	if resp, ok := c.mem.Get(key); ok {
+		return resp, true
+	}
+	resp, ok := c.disk.Get(key)
+	if !ok {
+		return nil, false
+	}
+	c.mem.Put(key, resp)
+	return resp, true
+}
+
+func (c *TieredCache) Put(key string, resp *CachedResponse) {
+	c.disk.Put(key, resp)
+	c.mem.Put(key, resp)
+}
+
+func (c *TieredCache) Delete(key string) {
+	c.mem.Delete(key)
+	c.disk.Delete(key)
+}
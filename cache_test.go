@@ -0,0 +1,126 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestMemoryCacheEvictsLeastRecentlyUsedWhenOverBudget(t *testing.T) {
+	c := NewMemoryCache(10) // bytes
+
+	c.Put("a", &CachedResponse{Body: []byte("aaaaa")}) // 5 bytes
+	c.Put("b", &CachedResponse{Body: []byte("bbbbb")}) // 5 bytes, total 10
+
+	if _, ok := c.Get("a"); !ok {
+		t.Fatalf("expected a to still be cached")
+	}
+
+	// Touching "a" makes "b" the least recently used, so adding "c" should
+	// evict "b", not "a".
+	c.Put("c", &CachedResponse{Body: []byte("ccccc")}) // pushes total to 15, over budget
+
+	if _, ok := c.Get("b"); ok {
+		t.Errorf("expected b to have been evicted")
+	}
+	if _, ok := c.Get("a"); !ok {
+		t.Errorf("expected a to survive eviction (was most recently used)")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Errorf("expected c to be cached")
+	}
+}
+
+func TestDiskCacheRoundTripAndRebuildsIndexOnStartup(t *testing.T) {
+	dir := t.TempDir()
+
+	c, err := NewDiskCache(dir, defaultDiskCacheBytes)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	resp := &CachedResponse{
+		Body:                []byte(`{"schemaVersion":2}`),
+		ContentType:         "application/vnd.oci.image.manifest.v1+json",
+		DockerContentDigest: "sha256:c0ffee",
+		StatusCode:          200,
+		FetchedAt:           time.Now(),
+	}
+	c.Put("manifests/library/alpine@sha256:c0ffee", resp)
+
+	// A fresh DiskCache over the same directory should rebuild its index
+	// by scanning the on-disk headers files.
+	reopened, err := NewDiskCache(dir, defaultDiskCacheBytes)
+	if err != nil {
+		t.Fatalf("NewDiskCache (reopen): %v", err)
+	}
+	if meta, ok := reopened.entries["manifests/library/alpine@sha256:c0ffee"]; !ok || meta.size != int64(len(resp.Body)) {
+		t.Errorf("rebuilt index entry = %+v (present %v), want size %d", meta, ok, len(resp.Body))
+	}
+	if reopened.curBytes != int64(len(resp.Body)) {
+		t.Errorf("rebuilt curBytes = %d, want %d", reopened.curBytes, len(resp.Body))
+	}
+
+	got, ok := reopened.Get("manifests/library/alpine@sha256:c0ffee")
+	if !ok {
+		t.Fatalf("expected cache entry to survive reopening the disk cache")
+	}
+	if string(got.Body) != string(resp.Body) {
+		t.Errorf("body = %q, want %q", got.Body, resp.Body)
+	}
+	if got.DockerContentDigest != resp.DockerContentDigest {
+		t.Errorf("digest = %q, want %q", got.DockerContentDigest, resp.DockerContentDigest)
+	}
+}
+
+func TestDiskCacheEvictsOldestEntriesWhenOverBudget(t *testing.T) {
+	dir := t.TempDir()
+	c, err := NewDiskCache(dir, 10) // bytes
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+
+	now := time.Now()
+	c.Put("a", &CachedResponse{Body: []byte("aaaaa"), FetchedAt: now})                      // 5 bytes
+	c.Put("b", &CachedResponse{Body: []byte("bbbbb"), FetchedAt: now.Add(time.Second)})     // 5 bytes, total 10
+	c.Put("c", &CachedResponse{Body: []byte("ccccc"), FetchedAt: now.Add(2 * time.Second)}) // pushes total to 15, over budget
+
+	if _, ok := c.Get("a"); ok {
+		t.Errorf("expected a (oldest FetchedAt) to have been evicted")
+	}
+	if _, ok := c.Get("b"); !ok {
+		t.Errorf("expected b to still be cached")
+	}
+	if _, ok := c.Get("c"); !ok {
+		t.Errorf("expected c to be cached")
+	}
+}
+
+func TestTieredCachePromotesDiskHitsToMemory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "disk")
+	disk, err := NewDiskCache(dir, defaultDiskCacheBytes)
+	if err != nil {
+		t.Fatalf("NewDiskCache: %v", err)
+	}
+	mem := NewMemoryCache(defaultMemoryCacheBytes)
+	tiered := NewTieredCache(mem, disk)
+
+	resp := &CachedResponse{Body: []byte("blob-bytes"), StatusCode: 200}
+	disk.Put("blobs/sha256:abc", resp) // seed disk only, bypassing the tiered Put
+
+	if _, ok := mem.Get("blobs/sha256:abc"); ok {
+		t.Fatalf("memory tier shouldn't have this entry yet")
+	}
+
+	got, ok := tiered.Get("blobs/sha256:abc")
+	if !ok {
+		t.Fatalf("expected tiered Get to fall back to disk")
+	}
+	if string(got.Body) != "blob-bytes" {
+		t.Errorf("body = %q, want %q", got.Body, "blob-bytes")
+	}
+
+	if _, ok := mem.Get("blobs/sha256:abc"); !ok {
+		t.Errorf("expected disk hit to be promoted into the memory tier")
+	}
+}
@@ -6,13 +6,69 @@ import (
 	"net/http/httputil"
 	"net/url"
 	"regexp"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// Default manifest cache lifetimes, overridable via WithManifestTTL and
+// WithManifestStaleTTL. A tag reference is served straight from cache
+// within manifestTTL; within manifestStaleTTL after that it's still
+// served immediately but revalidated against upstream in the background.
+const (
+	defaultManifestTTL      = 30 * time.Second
+	defaultManifestStaleTTL = 5 * time.Minute
 )
 
 type EdgeNest struct {
-	proxy *httputil.ReverseProxy
+	proxy       *httputil.ReverseProxy
+	upstreamURL *url.URL
+
+	// blobCacheDir is the root of the on-disk, content-addressable blob
+	// store. Blobs live under <blobCacheDir>/<algo>/<first2>/<rest> so a
+	// single directory never ends up with millions of entries.
+	blobCacheDir string
+
+	cache Cache
+
+	// sf coalesces concurrent identical upstream requests (e.g. a fleet
+	// of nodes all pulling the same manifest or blob at once) onto a
+	// single upstream call.
+	sf singleflight.Group
+
+	manifestTTL      time.Duration
+	manifestStaleTTL time.Duration
+	metrics          Metrics
+}
+
+// Option configures optional EdgeNest behaviour at construction time.
+type Option func(*EdgeNest)
+
+// WithCache overrides the default manifest Cache, e.g. to share a
+// TieredCache across instances or to use a fixed-size cache in tests.
+func WithCache(c Cache) Option {
+	return func(e *EdgeNest) { e.cache = c }
+}
+
+// WithManifestTTL overrides how long a cached tag reference is served
+// without any revalidation.
+func WithManifestTTL(d time.Duration) Option {
+	return func(e *EdgeNest) { e.manifestTTL = d }
+}
+
+// WithManifestStaleTTL overrides the grace period after manifestTTL
+// during which a stale tag entry is still served immediately while being
+// revalidated in the background.
+func WithManifestStaleTTL(d time.Duration) Option {
+	return func(e *EdgeNest) { e.manifestStaleTTL = d }
 }
 
-func NewEdgeNest(upstreamBase string) (*EdgeNest, error) {
+// WithMetrics overrides the default no-op Metrics sink.
+func WithMetrics(m Metrics) Option {
+	return func(e *EdgeNest) { e.metrics = m }
+}
+
+func NewEdgeNest(upstreamBase string, opts ...Option) (*EdgeNest, error) {
 	u, err := url.Parse(upstreamBase)
 	if err != nil {
 		return nil, err
@@ -26,38 +82,57 @@ func NewEdgeNest(upstreamBase string) (*EdgeNest, error) {
 	rp := httputil.NewSingleHostReverseProxy(u)
 
 	// Digests depend on content's exact bytes.
-	rp.Transport = &http.Transport{
+	baseTransport := &http.Transport{
 		DisableCompression: true,
 	}
+	// Transparently handles the registry token auth flow (Www-Authenticate:
+	// Bearer challenges) on top of the base transport.
+	rp.Transport = &authRoundTripper{
+		base: baseTransport,
+		auth: NewAuthenticator(),
+	}
+
+	blobCacheDir, err := defaultBlobCacheDir()
+	if err != nil {
+		return nil, err
+	}
 
-	return &EdgeNest{
-		proxy: rp,
-	}, nil
+	e := &EdgeNest{
+		proxy:            rp,
+		upstreamURL:      u,
+		blobCacheDir:     blobCacheDir,
+		cache:            NewCache(),
+		manifestTTL:      defaultManifestTTL,
+		manifestStaleTTL: defaultManifestStaleTTL,
+		metrics:          noopMetrics{},
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	return e, nil
+}
+
+// client returns an http.Client that reuses the proxy's transport, so
+// manual upstream requests (manifests, blobs and revalidations) pick up
+// the same digest-preserving settings and token auth as the reverse proxy.
+// Token endpoint requests use the Authenticator's own client instead.
+func (e *EdgeNest) client() *http.Client {
+	return &http.Client{Transport: e.proxy.Transport}
 }
 
 var manifestPathRegex = regexp.MustCompile(`^/v2/(.+)/manifests/([^/]+)$`)
+var blobPathRegex = regexp.MustCompile(`^/v2/(.+)/blobs/(sha256:[a-fA-F0-9]{64})$`)
 
 func (e *EdgeNest) RegisterRoutes(mux *http.ServeMux) {
 	mux.HandleFunc("/v2/", func(w http.ResponseWriter, r *http.Request) {
 		switch {
 		case manifestPathRegex.MatchString(r.URL.Path):
 			e.handleManifest(w, r)
+		case blobPathRegex.MatchString(r.URL.Path):
+			e.handleBlob(w, r)
 		default:
 			http.NotFound(w, r)
 		}
 	})
 }
 
-func (e *EdgeNest) handleManifest(w http.ResponseWriter, r *http.Request) {
-	switch r.Method {
-	case http.MethodGet, http.MethodHead:
-		// Cache hit - get from our cache.
-		//TODO - implement.
-
-		// Cache miss - proxy upstream request/response.
-		e.proxy.ServeHTTP(w, r)
-	default:
-		w.Header().Set("Allow", "GET, HEAD")
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
-	}
-}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"io"
 	"net/http"
 	"net/http/httptest"
@@ -8,11 +9,16 @@ import (
 	"testing"
 )
 
-func setupEdgeNestHandler(t *testing.T, upstreamHandler http.Handler) *http.ServeMux {
+func setupEdgeNestHandler(t *testing.T, upstreamHandler http.Handler, cache ...Cache) *http.ServeMux {
 	upstream := httptest.NewServer(upstreamHandler)
 	t.Cleanup(upstream.Close)
 
-	e, err := NewEdgeNest(upstream.URL)
+	var opts []Option
+	if len(cache) > 0 {
+		opts = append(opts, WithCache(cache[0]))
+	}
+
+	e, err := NewEdgeNest(upstream.URL, opts...)
 	if err != nil {
 		t.Fatalf("Failed to create EdgeNest: %v", err)
 	}
@@ -345,7 +351,16 @@ func TestManifestCaching(t *testing.T) {
 		upstreamHandler.ServeHTTP(upstreamRec, upstreamReq)
 
 		want := upstreamRec.Result()
-		defer want.Body.Close()
+		wantBody, err := io.ReadAll(want.Body)
+		want.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read want body: %v", err)
+		}
+		freshWant := func() *http.Response {
+			w := *want
+			w.Body = io.NopCloser(bytes.NewReader(wantBody))
+			return &w
+		}
 
 		rec1 := httptest.NewRecorder()
 		req1 := httptest.NewRequest(http.MethodGet, manifestPath, nil)
@@ -353,12 +368,12 @@ func TestManifestCaching(t *testing.T) {
 		got1 := rec1.Result()
 		defer got1.Body.Close()
 
-		assertManifestResponsesMatch(t, got1, want)
+		assertManifestResponsesMatch(t, got1, freshWant())
 		// We check that count is zero and later that count doesn't change,
 		// so that we can accomodate any retry logic with upstream without
 		// breaking this test.
 		if upstreamCallCount == 0 {
-			t.Errorf("After first request, upstream should have been called but it wasn't.", upstreamCallCount)
+			t.Errorf("After first request, upstream should have been called but it wasn't.")
 		}
 		upstreamCallCountAfterFirstRequest := upstreamCallCount
 
@@ -372,9 +387,71 @@ func TestManifestCaching(t *testing.T) {
 		defer got2.Body.Close()
 
 		if upstreamCallCount > upstreamCallCountAfterFirstRequest {
-			t.Errorf("after second request, upstream shouldn't be called. Upstream was called %d times, want $", upstreamCallCount, upstreamCallCountAfterFirstRequest)
+			t.Errorf("after second request, upstream shouldn't be called. Upstream was called %d times, want %d", upstreamCallCount, upstreamCallCountAfterFirstRequest)
+		}
+		assertManifestResponsesMatch(t, got2, freshWant())
+
+	})
+}
+
+// Behaviour:
+//   - A client requesting the OCI image index and a client requesting a
+//     platform-specific manifest for the same tag must each get the
+//     representation their Accept header negotiated, and repeat requests
+//     for either must be served from cache without a second upstream hit.
+func TestManifestAcceptNegotiation(t *testing.T) {
+	t.Run("different Accept headers for the same tag get distinct cached responses", func(t *testing.T) {
+		const manifestPath = "/v2/library/alpine/manifests/latest"
+		const indexBody = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.index.v1+json"}`
+		const indexDigest = "sha256:index000"
+		const platformBody = `{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json"}`
+		const platformDigest = "sha256:platform000"
+
+		upstreamCallCount := 0
+		upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			upstreamCallCount++
+			if strings.Contains(r.Header.Get("Accept"), "image.index") {
+				w.Header().Set("Content-Type", "application/vnd.oci.image.index.v1+json")
+				w.Header().Set("Docker-Content-Digest", indexDigest)
+				w.WriteHeader(http.StatusOK)
+				_, _ = io.WriteString(w, indexBody)
+				return
+			}
+			w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+			w.Header().Set("Docker-Content-Digest", platformDigest)
+			w.WriteHeader(http.StatusOK)
+			_, _ = io.WriteString(w, platformBody)
+		})
+
+		mux := setupEdgeNestHandler(t, upstreamHandler)
+
+		getWithAccept := func(accept string) *http.Response {
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, manifestPath, nil)
+			req.Header.Set("Accept", accept)
+			mux.ServeHTTP(rec, req)
+			return rec.Result()
 		}
-		assertManifestResponsesMatch(t, got2, want)
 
+		indexResp := getWithAccept("application/vnd.oci.image.index.v1+json")
+		platformResp := getWithAccept("application/vnd.oci.image.manifest.v1+json")
+
+		if indexResp.Header.Get("Docker-Content-Digest") != indexDigest {
+			t.Errorf("index digest = %q, want %q", indexResp.Header.Get("Docker-Content-Digest"), indexDigest)
+		}
+		if platformResp.Header.Get("Docker-Content-Digest") != platformDigest {
+			t.Errorf("platform digest = %q, want %q", platformResp.Header.Get("Docker-Content-Digest"), platformDigest)
+		}
+		if upstreamCallCount != 2 {
+			t.Fatalf("expected 2 upstream calls after first requests, got %d", upstreamCallCount)
+		}
+
+		// Repeat both requests; neither should hit upstream again.
+		getWithAccept("application/vnd.oci.image.index.v1+json")
+		getWithAccept("application/vnd.oci.image.manifest.v1+json")
+
+		if upstreamCallCount != 2 {
+			t.Errorf("repeat requests should be served from cache, upstream called %d times", upstreamCallCount)
+		}
 	})
 }
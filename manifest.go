@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// defaultManifestAccept is sent upstream when the client didn't specify
+// one, covering both OCI and legacy Docker manifest/index media types so
+// registries negotiate sensibly regardless of which spec they speak.
+const defaultManifestAccept = "application/vnd.oci.image.manifest.v1+json, " +
+	"application/vnd.oci.image.index.v1+json, " +
+	"application/vnd.docker.distribution.manifest.v2+json, " +
+	"application/vnd.docker.distribution.manifest.list.v2+json"
+
+// digestReferenceRegex matches an OCI content digest (e.g.
+// "sha256:deadbeef..."), as opposed to a tag.
+var digestReferenceRegex = regexp.MustCompile(`^[a-z0-9]+(?:[.+_-][a-z0-9]+)*:[a-fA-F0-9]{32,}$`)
+
+func isDigestReference(reference string) bool {
+	return digestReferenceRegex.MatchString(reference)
+}
+
+// acceptSetKey canonicalizes an Accept header into a stable, order-
+// independent string, so "a, b" and "b,a" hit the same cache entry.
+func acceptSetKey(accept string) string {
+	parts := strings.Split(accept, ",")
+	for i, p := range parts {
+		parts[i] = strings.TrimSpace(p)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "+")
+}
+
+// manifestContentKey is the cache key for a manifest's actual content,
+// addressed by its immutable digest. The digest alone already
+// disambiguates different media-type representations, since upstream
+// returns a different digest per representation.
+func manifestContentKey(name, digest string) string {
+	return "manifests/" + name + "@" + digest
+}
+
+// manifestTagKey is the cache key for a tag pointer: it stores only the
+// digest the tag currently resolves to for a given Accept set, so
+// re-tagging invalidates the pointer without touching the (still-valid)
+// content entry, and two clients requesting the same tag with different
+// Accept headers don't clobber each other's pointer.
+func manifestTagKey(name, reference, accept string) string {
+	return "manifests/" + name + ":" + reference + "#" + acceptSetKey(accept)
+}
+
+// upstreamManifestResult is the shared outcome of a single upstream
+// manifest fetch, buffered fully in memory so it can be handed to every
+// request coalesced onto it by EdgeNest.sf.
+type upstreamManifestResult struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// fetchManifestUpstream issues a single manifest request upstream and
+// buffers the full response. It's always called through e.sf so
+// concurrent identical requests share one call.
+func (e *EdgeNest) fetchManifestUpstream(ctx context.Context, method, path, accept string) (*upstreamManifestResult, error) {
+	u := *e.upstreamURL
+	u.Path = path
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+
+	resp, err := e.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &upstreamManifestResult{status: resp.StatusCode, header: resp.Header.Clone(), body: body}, nil
+}
+
+func (e *EdgeNest) handleManifest(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	default:
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	m := manifestPathRegex.FindStringSubmatch(r.URL.Path)
+	name, reference := m[1], m[2]
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		accept = defaultManifestAccept
+	}
+
+	if e.serveFromManifestCache(w, r, name, reference, accept) {
+		return
+	}
+
+	e.metrics.Inc(metricCacheMiss)
+
+	// Coalesce concurrent identical requests (common when many compute
+	// nodes start at once) onto a single upstream call.
+	sfKey := r.Method + " " + r.URL.Path + "#" + acceptSetKey(accept)
+	v, err, _ := e.sf.Do(sfKey, func() (interface{}, error) {
+		res, err := e.fetchManifestUpstream(context.Background(), r.Method, r.URL.Path, accept)
+		if err != nil {
+			return nil, err
+		}
+		if res.status == http.StatusOK && r.Method == http.MethodGet {
+			e.storeManifestCache(name, reference, accept, res.header.Get("Content-Type"), res.header.Get("Docker-Content-Digest"), res.body)
+		}
+		return res, nil
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	res := v.(*upstreamManifestResult)
+	for k, vals := range res.header {
+		for _, val := range vals {
+			w.Header().Add(k, val)
+		}
+	}
+	w.WriteHeader(res.status)
+	if r.Method == http.MethodGet {
+		w.Write(res.body)
+	}
+}
+
+// serveFromManifestCache writes a cached manifest to w and reports
+// whether it did so. Digest-pinned references are served forever without
+// revalidation; tag references are served fresh as-is within
+// manifestTTL, and within the manifestTTL+manifestStaleTTL grace period
+// they're served immediately while a background revalidation runs.
+func (e *EdgeNest) serveFromManifestCache(w http.ResponseWriter, r *http.Request, name, reference, accept string) bool {
+	if isDigestReference(reference) {
+		cached, ok := e.cache.Get(manifestContentKey(name, reference))
+		if !ok {
+			return false
+		}
+		e.metrics.Inc(metricCacheHit)
+		writeCachedManifest(w, r, cached)
+		return true
+	}
+
+	ptr, ok := e.cache.Get(manifestTagKey(name, reference, accept))
+	if !ok || ptr.DockerContentDigest == "" {
+		return false
+	}
+	cached, ok := e.cache.Get(manifestContentKey(name, ptr.DockerContentDigest))
+	if !ok {
+		return false
+	}
+
+	age := time.Since(cached.FetchedAt)
+	switch {
+	case age < e.manifestTTL:
+		e.metrics.Inc(metricCacheHit)
+		writeCachedManifest(w, r, cached)
+		return true
+	case age < e.manifestTTL+e.manifestStaleTTL:
+		e.metrics.Inc(metricCacheStaleServed)
+		writeCachedManifest(w, r, cached)
+		e.revalidateManifestAsync(name, reference, accept, cached.DockerContentDigest)
+		return true
+	default:
+		return false
+	}
+}
+
+// revalidateManifestAsync checks whether a stale tag's manifest changed
+// upstream, using If-None-Match so an unchanged manifest costs upstream a
+// cheap 304 instead of a full body transfer. It's coalesced through e.sf
+// so the many requests a stale tag gets at once (the same thundering-herd
+// scenario sf already guards fresh misses against) trigger one upstream
+// revalidation instead of one per request.
+func (e *EdgeNest) revalidateManifestAsync(name, reference, accept, digest string) {
+	sfKey := "revalidate " + name + ":" + reference + "#" + acceptSetKey(accept)
+
+	go e.sf.Do(sfKey, func() (interface{}, error) {
+		e.metrics.Inc(metricCacheRevalidate)
+
+		u := *e.upstreamURL
+		u.Path = "/v2/" + name + "/manifests/" + reference
+
+		req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", accept)
+		req.Header.Set("If-None-Match", fmt.Sprintf("%q", digest))
+
+		resp, err := e.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode == http.StatusNotModified {
+			e.bumpManifestFetchedAt(name, digest)
+			return nil, nil
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		newDigest := resp.Header.Get("Docker-Content-Digest")
+		if newDigest == "" || newDigest == digest {
+			e.bumpManifestFetchedAt(name, digest)
+			return nil, nil
+		}
+
+		e.storeManifestCache(name, reference, accept, resp.Header.Get("Content-Type"), newDigest, body)
+		return nil, nil
+	})
+}
+
+// bumpManifestFetchedAt refreshes a content entry's timestamp, used when
+// revalidation confirms the cached manifest is still current. It Puts a
+// new CachedResponse rather than mutating the one Get returned in place,
+// since a Cache implementation may hand back a pointer it still holds
+// internally (e.g. MemoryCache's LRU entry) that concurrent readers could
+// be reading from at the same time.
+func (e *EdgeNest) bumpManifestFetchedAt(name, digest string) {
+	key := manifestContentKey(name, digest)
+	cached, ok := e.cache.Get(key)
+	if !ok {
+		return
+	}
+	refreshed := *cached
+	refreshed.FetchedAt = time.Now()
+	e.cache.Put(key, &refreshed)
+}
+
+func (e *EdgeNest) storeManifestCache(name, reference, accept, contentType, digest string, body []byte) {
+	now := time.Now()
+
+	e.cache.Put(manifestContentKey(name, digest), &CachedResponse{
+		Body:                body,
+		ContentType:         contentType,
+		DockerContentDigest: digest,
+		StatusCode:          http.StatusOK,
+		FetchedAt:           now,
+	})
+
+	if reference != digest {
+		e.cache.Put(manifestTagKey(name, reference, accept), &CachedResponse{
+			DockerContentDigest: digest,
+			StatusCode:          http.StatusOK,
+			FetchedAt:           now,
+		})
+	}
+}
+
+func writeCachedManifest(w http.ResponseWriter, r *http.Request, cached *CachedResponse) {
+	w.Header().Set("Content-Type", cached.ContentType)
+	w.Header().Set("Docker-Content-Digest", cached.DockerContentDigest)
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		w.Write(cached.Body)
+	}
+}
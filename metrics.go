@@ -0,0 +1,20 @@
+package main
+
+// Metrics receives counters for cache behaviour so EdgeNest can be wired
+// into whatever observability stack a deployment already has.
+type Metrics interface {
+	Inc(name string)
+}
+
+// Cache event names reported via Metrics.Inc.
+const (
+	metricCacheHit         = "cache_hit"
+	metricCacheMiss        = "cache_miss"
+	metricCacheRevalidate  = "cache_revalidate"
+	metricCacheStaleServed = "cache_stale_served"
+)
+
+// noopMetrics is the default Metrics used when no Option overrides it.
+type noopMetrics struct{}
+
+func (noopMetrics) Inc(name string) {}
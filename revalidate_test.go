@@ -0,0 +1,214 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Behaviour:
+//   - Once a tag's cache entry goes stale but is still within its grace
+//     period, EdgeNest must serve the stale entry immediately and
+//     revalidate against upstream in the background; if the digest
+//     changed, later requests should see the new content.
+func TestManifestStaleWhileRevalidate(t *testing.T) {
+	const manifestPath = "/v2/library/alpine/manifests/latest"
+	const bodyA = `{"schemaVersion":2,"v":"a"}`
+	const digestA = "sha256:aaaa"
+	const bodyB = `{"schemaVersion":2,"v":"b"}`
+	const digestB = "sha256:bbbb"
+
+	var upstreamCallCount int64
+	var currentDigest atomic.Value
+	currentDigest.Store(digestA)
+
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&upstreamCallCount, 1)
+		digest := currentDigest.Load().(string)
+
+		if inm := r.Header.Get("If-None-Match"); inm != "" && inm == fmt.Sprintf("%q", digest) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusOK)
+		if digest == digestA {
+			_, _ = io.WriteString(w, bodyA)
+		} else {
+			_, _ = io.WriteString(w, bodyB)
+		}
+	})
+
+	upstream := httptest.NewServer(upstreamHandler)
+	defer upstream.Close()
+
+	e, err := NewEdgeNest(upstream.URL,
+		WithManifestTTL(10*time.Millisecond),
+		WithManifestStaleTTL(time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewEdgeNest: %v", err)
+	}
+	mux := http.NewServeMux()
+	e.RegisterRoutes(mux)
+
+	get := func() *http.Response {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, manifestPath, nil)
+		mux.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	first := get()
+	if got := first.Header.Get("Docker-Content-Digest"); got != digestA {
+		t.Fatalf("first response digest = %q, want %q", got, digestA)
+	}
+
+	// Let the fresh TTL lapse so the next request falls into the stale
+	// grace window, and flip upstream to a new digest.
+	time.Sleep(20 * time.Millisecond)
+	currentDigest.Store(digestB)
+
+	stale := get()
+	if got := stale.Header.Get("Docker-Content-Digest"); got != digestA {
+		t.Fatalf("stale response digest = %q, want %q (should serve stale immediately)", got, digestA)
+	}
+
+	// Give the background revalidation goroutine a moment to land the
+	// new digest in the cache.
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if atomic.LoadInt64(&upstreamCallCount) >= 2 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	refreshed := get()
+	if got := refreshed.Header.Get("Docker-Content-Digest"); got != digestB {
+		t.Errorf("refreshed response digest = %q, want %q", got, digestB)
+	}
+}
+
+// Behaviour:
+//   - A manifest requested by digest is immutable, so it must be served
+//     from cache forever without ever triggering a revalidation, no
+//     matter how small the configured TTLs are.
+func TestManifestPinnedByDigestNeverRevalidates(t *testing.T) {
+	const name = "library/alpine"
+	const digest = "sha256:c0ffeec0ffeec0ffeec0ffeec0ffeec0ffeec0ffeec0ffeec0ffeec0ffeec0ff"
+	const manifestPath = "/v2/" + name + "/manifests/" + digest
+	const manifestBody = `{"schemaVersion":2}`
+
+	var upstreamCallCount int64
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&upstreamCallCount, 1)
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, manifestBody)
+	})
+
+	upstream := httptest.NewServer(upstreamHandler)
+	defer upstream.Close()
+
+	e, err := NewEdgeNest(upstream.URL,
+		WithManifestTTL(time.Nanosecond),
+		WithManifestStaleTTL(time.Nanosecond),
+	)
+	if err != nil {
+		t.Fatalf("NewEdgeNest: %v", err)
+	}
+	mux := http.NewServeMux()
+	e.RegisterRoutes(mux)
+
+	get := func() *http.Response {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, manifestPath, nil)
+		mux.ServeHTTP(rec, req)
+		return rec.Result()
+	}
+
+	get()
+	time.Sleep(10 * time.Millisecond)
+	get()
+
+	if got := atomic.LoadInt64(&upstreamCallCount); got != 1 {
+		t.Errorf("upstreamCallCount = %d, want 1 (digest-pinned entries must never revalidate)", got)
+	}
+}
+
+// Behaviour:
+//   - Many concurrent requests landing on the same stale tag must
+//     coalesce onto a single upstream revalidation instead of each firing
+//     their own, the same thundering-herd protection sf already gives
+//     fresh cache misses.
+func TestManifestConcurrentRevalidationsCoalesceToOneUpstreamCall(t *testing.T) {
+	const manifestPath = "/v2/library/alpine/manifests/latest"
+	const digest = "sha256:c0ffee"
+
+	var upstreamCallCount int64
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&upstreamCallCount, 1)
+		// Hold each revalidation open briefly so concurrent requests are
+		// guaranteed to overlap instead of serializing through quickly.
+		time.Sleep(20 * time.Millisecond)
+		if inm := r.Header.Get("If-None-Match"); inm == fmt.Sprintf("%q", digest) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Docker-Content-Digest", digest)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, `{"schemaVersion":2}`)
+	})
+
+	upstream := httptest.NewServer(upstreamHandler)
+	defer upstream.Close()
+
+	e, err := NewEdgeNest(upstream.URL,
+		WithManifestTTL(time.Nanosecond),
+		WithManifestStaleTTL(time.Minute),
+	)
+	if err != nil {
+		t.Fatalf("NewEdgeNest: %v", err)
+	}
+	mux := http.NewServeMux()
+	e.RegisterRoutes(mux)
+
+	get := func() {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, manifestPath, nil)
+		mux.ServeHTTP(rec, req)
+	}
+
+	// Warm the cache, then let it go stale.
+	get()
+	time.Sleep(5 * time.Millisecond)
+
+	const concurrency = 50
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			get()
+		}()
+	}
+	wg.Wait()
+
+	// Give the (coalesced) background revalidation a moment to finish.
+	time.Sleep(100 * time.Millisecond)
+
+	if got := atomic.LoadInt64(&upstreamCallCount); got != 2 {
+		t.Errorf("upstreamCallCount = %d, want 2 (1 initial fetch + 1 coalesced revalidation)", got)
+	}
+}
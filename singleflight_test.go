@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Behaviour:
+//   - When many identical manifest requests arrive concurrently, EdgeNest
+//     must issue exactly one upstream call and serve every caller the same,
+//     correct response.
+func TestManifestConcurrentRequestsCoalesceToOneUpstreamCall(t *testing.T) {
+	const manifestBody = `{"schemaVersion":2}`
+	const manifestDigest = "sha256:c0ffee"
+	const manifestPath = "/v2/library/alpine/manifests/latest"
+
+	var upstreamCallCount int64
+	upstreamHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&upstreamCallCount, 1)
+		// Give concurrent requests a chance to pile up behind the first one.
+		time.Sleep(20 * time.Millisecond)
+		w.Header().Set("Content-Type", "application/vnd.oci.image.manifest.v1+json")
+		w.Header().Set("Docker-Content-Digest", manifestDigest)
+		w.WriteHeader(http.StatusOK)
+		_, _ = io.WriteString(w, manifestBody)
+	})
+
+	mux := setupEdgeNestHandler(t, upstreamHandler)
+
+	const concurrency = 50
+	var wg sync.WaitGroup
+	bodies := make([]string, concurrency)
+	digests := make([]string, concurrency)
+
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest(http.MethodGet, manifestPath, nil)
+			mux.ServeHTTP(rec, req)
+			bodies[i] = rec.Body.String()
+			digests[i] = rec.Header().Get("Docker-Content-Digest")
+		}(i)
+	}
+	wg.Wait()
+
+	if got := atomic.LoadInt64(&upstreamCallCount); got != 1 {
+		t.Errorf("upstreamCallCount = %d, want 1", got)
+	}
+	for i := range bodies {
+		if bodies[i] != manifestBody {
+			t.Errorf("response %d body = %q, want %q", i, bodies[i], manifestBody)
+		}
+		if digests[i] != manifestDigest {
+			t.Errorf("response %d digest = %q, want %q", i, digests[i], manifestDigest)
+		}
+	}
+}